@@ -0,0 +1,195 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//---------------------------------------------------------------------------------------
+// implements flow-controlled dispatch of outgoing hold requests
+
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	"sync"
+	"time"
+)
+
+// Defaults for a World's HoldRequestScheduler, used until SetHoldRequestScheduler
+// installs one tuned for the node's actual network conditions.
+const (
+	DefaultHoldSchedulerBufLimit    = 64.0
+	DefaultHoldSchedulerMinRecharge = 8.0
+	DefaultHoldSchedulerMaxAge      = 30 * time.Second
+)
+
+// HoldRequestScheduler flow-controls outgoing hold requests per peer with a
+// token bucket: each peer gets bufLimit bytes of budget, refilled at
+// minRecharge bytes/sec. Requests that would exceed the current budget are
+// queued FIFO per peer and dispatched as capacity frees up; anything that
+// sits queued longer than maxAge is dropped rather than sent stale. This
+// keeps a responsibility-storm (a big hash arriving, or many peers joining
+// at once) from fanning out an unbounded burst of hold requests.
+type HoldRequestScheduler struct {
+	bufLimit    float64
+	minRecharge float64
+	maxAge      time.Duration
+	now         func() time.Time
+	dispatch    func(peer.ID, interface{})
+
+	lk           sync.Mutex
+	buckets      map[peer.ID]*tokenBucket
+	queues       map[peer.ID][]*holdSchedulerRequest
+	inFlight     map[peer.ID]int
+	dropped      int
+	latencySum   time.Duration
+	latencyCount int
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// holdSchedulerRequest queues a single flow-controlled send to a peer.
+// payload is opaque to the scheduler: it's typically a Hash for a single
+// hold request, or a HoldReqBatch when a caller has folded several hashes
+// for the same peer into one request.
+type holdSchedulerRequest struct {
+	payload  interface{}
+	cost     float64
+	queuedAt time.Time
+}
+
+// HoldRequestSchedulerStats summarizes a HoldRequestScheduler's state, for
+// monitoring and tests.
+type HoldRequestSchedulerStats struct {
+	Queued      int
+	InFlight    int
+	Dropped     int
+	MeanLatency time.Duration
+}
+
+// NewHoldRequestScheduler creates a scheduler that calls dispatch once a
+// request clears flow control. dispatch is expected to be non-blocking
+// (e.g. hand off to the network layer); the caller must call Complete once
+// the corresponding response (or a timeout) arrives, so InFlight stays
+// accurate. A nil dispatch is valid and simply drops cleared requests,
+// useful before the node has wired up a real send path.
+func NewHoldRequestScheduler(bufLimit, minRecharge float64, maxAge time.Duration, dispatch func(peer.ID, interface{})) *HoldRequestScheduler {
+	return &HoldRequestScheduler{
+		bufLimit:    bufLimit,
+		minRecharge: minRecharge,
+		maxAge:      maxAge,
+		now:         time.Now,
+		dispatch:    dispatch,
+		buckets:     make(map[peer.ID]*tokenBucket),
+		queues:      make(map[peer.ID][]*holdSchedulerRequest),
+		inFlight:    make(map[peer.ID]int),
+	}
+}
+
+// Enqueue submits payload (typically a Hash, or a HoldReqBatch of several)
+// to be sent to p once p's token bucket has cost available, dropping it
+// first if it ages out of maxAge.
+func (s *HoldRequestScheduler) Enqueue(p peer.ID, payload interface{}, cost float64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.queues[p] = append(s.queues[p], &holdSchedulerRequest{payload: payload, cost: cost, queuedAt: s.now()})
+	s.drainLocked(p)
+}
+
+// Complete marks one in-flight request to p as finished, whether answered or
+// given up on, freeing its InFlight accounting slot.
+func (s *HoldRequestScheduler) Complete(p peer.ID) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.inFlight[p] > 0 {
+		s.inFlight[p]--
+	}
+}
+
+// Tick re-attempts draining every peer's queue, so requests get dispatched
+// as tokens accrue even without a fresh Enqueue, and so anything that has
+// aged out gets dropped promptly rather than at the next Enqueue.
+func (s *HoldRequestScheduler) Tick() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for p := range s.queues {
+		s.drainLocked(p)
+	}
+}
+
+func (s *HoldRequestScheduler) drainLocked(p peer.ID) {
+	now := s.now()
+	bucket := s.bucketLocked(p, now)
+	queue := s.queues[p]
+	i := 0
+	for i < len(queue) {
+		req := queue[i]
+		if now.Sub(req.queuedAt) > s.maxAge {
+			s.dropped++
+			i++
+			continue
+		}
+		// A request whose cost exceeds bufLimit (e.g. an oversized
+		// HoldReqBatch) could otherwise never clear a bucket that tops out
+		// at bufLimit, blocking the rest of this peer's queue until maxAge
+		// drops it. Clamp what we require to bufLimit so such a request
+		// still waits for a full bucket, but can actually go out once one.
+		required := req.cost
+		if required > s.bufLimit {
+			required = s.bufLimit
+		}
+		if bucket.tokens < required {
+			break
+		}
+		bucket.tokens -= req.cost
+		if bucket.tokens < 0 {
+			bucket.tokens = 0
+		}
+		s.latencySum += now.Sub(req.queuedAt)
+		s.latencyCount++
+		s.inFlight[p]++
+		if s.dispatch != nil {
+			s.dispatch(p, req.payload)
+		}
+		i++
+	}
+	remaining := make([]*holdSchedulerRequest, len(queue)-i)
+	copy(remaining, queue[i:])
+	s.queues[p] = remaining
+}
+
+func (s *HoldRequestScheduler) bucketLocked(p peer.ID, now time.Time) *tokenBucket {
+	b, ok := s.buckets[p]
+	if !ok {
+		b = &tokenBucket{tokens: s.bufLimit, lastRefill: now}
+		s.buckets[p] = b
+		return b
+	}
+	if elapsed := now.Sub(b.lastRefill).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * s.minRecharge
+		if b.tokens > s.bufLimit {
+			b.tokens = s.bufLimit
+		}
+		b.lastRefill = now
+	}
+	return b
+}
+
+// Stats reports the scheduler's current queued/in-flight/dropped counts and
+// the mean time a dispatched request spent queued.
+func (s *HoldRequestScheduler) Stats() HoldRequestSchedulerStats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	queued := 0
+	for _, q := range s.queues {
+		queued += len(q)
+	}
+	inFlight := 0
+	for _, n := range s.inFlight {
+		inFlight += n
+	}
+	var mean time.Duration
+	if s.latencyCount > 0 {
+		mean = s.latencySum / time.Duration(s.latencyCount)
+	}
+	return HoldRequestSchedulerStats{Queued: queued, InFlight: inFlight, Dropped: s.dropped, MeanLatency: mean}
+}
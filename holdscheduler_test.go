@@ -0,0 +1,103 @@
+package holochain
+
+import (
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"testing"
+	"time"
+)
+
+func TestHoldRequestSchedulerHonorsTokenBucket(t *testing.T) {
+	const numPeers = 50
+	const requestsPerPeer = 200 // 10k total
+	const cost = 1.0
+	const bufLimit = 20.0
+	const minRecharge = 5.0
+
+	dispatchedAt := make(map[peer.ID][]time.Time)
+
+	start := time.Unix(0, 0)
+	clock := start
+	scheduler := NewHoldRequestScheduler(bufLimit, minRecharge, time.Hour, func(p peer.ID, payload interface{}) {
+		dispatchedAt[p] = append(dispatchedAt[p], clock)
+	})
+	scheduler.now = func() time.Time { return clock }
+
+	ids := make([]peer.ID, numPeers)
+	for i := range ids {
+		ids[i] = testPeerID(fmt.Sprintf("peer-%d", i))
+	}
+
+	var hash Hash
+	for _, id := range ids {
+		for i := 0; i < requestsPerPeer; i++ {
+			scheduler.Enqueue(id, hash, cost)
+		}
+	}
+
+	// Plenty of time for every peer's backlog to drain at minRecharge/sec.
+	for i := 0; i < 100; i++ {
+		clock = clock.Add(time.Second)
+		scheduler.Tick()
+	}
+
+	for _, id := range ids {
+		times := dispatchedAt[id]
+		if len(times) != requestsPerPeer {
+			t.Fatalf("peer %v: expected all %d requests to eventually dispatch, got %d", id, requestsPerPeer, len(times))
+		}
+		for i, ts := range times {
+			elapsed := ts.Sub(start).Seconds()
+			maxAllowed := bufLimit + minRecharge*elapsed + 1e-9
+			if float64(i+1)*cost > maxAllowed {
+				t.Fatalf("peer %v exceeded its token bucket: request %d sent at t=%.0fs, bucket only allows %.2f by then", id, i+1, elapsed, maxAllowed)
+			}
+		}
+	}
+
+	stats := scheduler.Stats()
+	if stats.Dropped != 0 {
+		t.Fatalf("expected no drops with a generous maxAge, got %d", stats.Dropped)
+	}
+	if stats.Queued != 0 {
+		t.Fatalf("expected every peer's queue to fully drain, got %d still queued", stats.Queued)
+	}
+}
+
+func TestHoldRequestSchedulerDropsStaleRequests(t *testing.T) {
+	clock := time.Unix(0, 0)
+	scheduler := NewHoldRequestScheduler(1, 0, 10*time.Second, func(p peer.ID, payload interface{}) {})
+	scheduler.now = func() time.Time { return clock }
+
+	id := testPeerID("peer")
+	var hash Hash
+	scheduler.Enqueue(id, hash, 1) // consumes the single initial token
+	scheduler.Enqueue(id, hash, 1) // queues, no recharge configured
+
+	clock = clock.Add(11 * time.Second)
+	scheduler.Tick()
+
+	stats := scheduler.Stats()
+	if stats.Dropped != 1 {
+		t.Fatalf("expected the stale queued request to be dropped, got dropped=%d", stats.Dropped)
+	}
+	if stats.Queued != 0 {
+		t.Fatalf("expected the queue to be empty after the drop, got %d", stats.Queued)
+	}
+}
+
+func TestHoldRequestSchedulerCompleteTracksInFlight(t *testing.T) {
+	scheduler := NewHoldRequestScheduler(5, 0, time.Hour, func(p peer.ID, payload interface{}) {})
+	id := testPeerID("peer")
+	var hash Hash
+
+	scheduler.Enqueue(id, hash, 1)
+	if stats := scheduler.Stats(); stats.InFlight != 1 {
+		t.Fatalf("expected 1 in-flight request after dispatch, got %d", stats.InFlight)
+	}
+
+	scheduler.Complete(id)
+	if stats := scheduler.Stats(); stats.InFlight != 0 {
+		t.Fatalf("expected in-flight count to drop back to 0 after Complete, got %d", stats.InFlight)
+	}
+}
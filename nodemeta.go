@@ -0,0 +1,255 @@
+// Copyright (C) 2013-2018, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//---------------------------------------------------------------------------------------
+// implements gossiped, signed peer metadata for the world model
+
+package holochain
+
+import (
+	"encoding/json"
+	"errors"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	"sync"
+	"time"
+)
+
+// NodeMeta gossip message types, exchanged so peers can sync their
+// NodeMetaTables by version vector rather than re-sending the whole table.
+const (
+	NODEMETA_REQUEST  = "NODEMETA_REQUEST"
+	NODEMETA_RESPONSE = "NODEMETA_RESPONSE"
+)
+
+var (
+	ErrInvalidNodeMeta          = errors.New("invalid node meta")
+	ErrNodeMetaSignature        = errors.New("node meta signature invalid")
+	ErrNodeMetaStale            = errors.New("node meta version is not newer than the known version")
+	ErrNodeMetaIdentityMismatch = errors.New("node meta PeerInfo.ID does not match PubKey")
+)
+
+// NodeMeta is a peer's self-asserted, signed advertisement of its identity
+// and capabilities, e.g. "warrant-holder" or "bridge-genome-X", gossiped
+// between nodes so the world model can discover peers and their tags
+// without every peer having to be added manually via World.AddNode.
+type NodeMeta struct {
+	PeerInfo  pstore.PeerInfo
+	PubKey    ic.PubKey
+	Version   uint64
+	Timestamp time.Time
+	Tags      map[string]string
+	Signature []byte
+}
+
+// signableBytes returns the canonical encoding of the meta that gets signed
+// and verified, i.e. everything except the signature itself. It includes
+// PeerInfo.Addrs so a relayer can't rewrite a peer's advertised addresses
+// in transit without invalidating the signature.
+func (meta *NodeMeta) signableBytes() ([]byte, error) {
+	return json.Marshal(struct {
+		ID        peer.ID
+		Addrs     []ma.Multiaddr
+		Version   uint64
+		Timestamp time.Time
+		Tags      map[string]string
+	}{meta.PeerInfo.ID, meta.PeerInfo.Addrs, meta.Version, meta.Timestamp, meta.Tags})
+}
+
+// NewNodeMeta builds and signs a NodeMeta using the given private key, which
+// must correspond to pubKey.
+func NewNodeMeta(pi pstore.PeerInfo, priv ic.PrivKey, pubKey ic.PubKey, version uint64, tags map[string]string, timestamp time.Time) (meta *NodeMeta, err error) {
+	meta = &NodeMeta{PeerInfo: pi, PubKey: pubKey, Version: version, Timestamp: timestamp, Tags: tags}
+	bytes, err := meta.signableBytes()
+	if err != nil {
+		return nil, err
+	}
+	meta.Signature, err = priv.Sign(bytes)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// verify checks that meta was really signed by meta.PubKey, and that
+// meta.PubKey is actually the key meta.PeerInfo.ID was derived from, so a
+// holder of any valid keypair can't sign a meta claiming someone else's ID
+// and overwrite that peer's NodeRecord.
+func (meta *NodeMeta) verify() (err error) {
+	if meta == nil || meta.PubKey == nil {
+		err = ErrInvalidNodeMeta
+		return
+	}
+	derivedID, err := peer.IDFromPublicKey(meta.PubKey)
+	if err != nil {
+		return
+	}
+	if derivedID != meta.PeerInfo.ID {
+		err = ErrNodeMetaIdentityMismatch
+		return
+	}
+	bytes, err := meta.signableBytes()
+	if err != nil {
+		return
+	}
+	// some key types (e.g. RSA) report a bad signature as an error rather
+	// than as ok == false; both mean the signature didn't verify.
+	ok, err := meta.PubKey.Verify(bytes, meta.Signature)
+	if err != nil || !ok {
+		err = ErrNodeMetaSignature
+	}
+	return
+}
+
+// NodeMetaTable stores the newest signed NodeMeta seen for each peer, so a
+// node's advertised identity and capabilities can be gossiped and trusted
+// without just taking an unsigned, unversioned claim at face value.
+type NodeMetaTable struct {
+	lk    sync.RWMutex
+	metas map[peer.ID]*NodeMeta
+}
+
+// NewNodeMetaTable creates an empty NodeMetaTable.
+func NewNodeMetaTable() *NodeMetaTable {
+	return &NodeMetaTable{metas: make(map[peer.ID]*NodeMeta)}
+}
+
+func (t *NodeMetaTable) get(id peer.ID) *NodeMeta {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	return t.metas[id]
+}
+
+func (t *NodeMetaTable) put(meta *NodeMeta) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.metas[meta.PeerInfo.ID] = meta
+}
+
+// Versions returns this table's version vector: the newest known Version
+// per peer, used to ask a gossip partner for only what's changed.
+func (t *NodeMetaTable) Versions() map[peer.ID]uint64 {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	versions := make(map[peer.ID]uint64, len(t.metas))
+	for id, meta := range t.metas {
+		versions[id] = meta.Version
+	}
+	return versions
+}
+
+// Since returns the NodeMeta entries that are newer than the given version
+// vector, i.e. the delta a gossip partner would need to catch up.
+func (t *NodeMetaTable) Since(versions map[peer.ID]uint64) []*NodeMeta {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	var delta []*NodeMeta
+	for id, meta := range t.metas {
+		if meta.Version > versions[id] {
+			delta = append(delta, meta)
+		}
+	}
+	return delta
+}
+
+// PutMeta verifies meta's signature against its own PubKey, rejects it if
+// it's not newer than the version already on file, and otherwise records it
+// and upserts the corresponding NodeRecord, so new peers appear in the
+// world model via meta gossip instead of requiring a manual AddNode call.
+func (world *World) PutMeta(meta *NodeMeta) (err error) {
+	err = meta.verify()
+	if err != nil {
+		return
+	}
+	if existing := world.metaTable.get(meta.PeerInfo.ID); existing != nil && meta.Version <= existing.Version {
+		err = ErrNodeMetaStale
+		return
+	}
+	world.metaTable.put(meta)
+
+	world.lk.Lock()
+	old := world.loadNodes().byID[meta.PeerInfo.ID]
+	isNew := old == nil
+	updated := &NodeRecord{PeerInfo: meta.PeerInfo, PubKey: meta.PubKey, IsHolding: make(map[Hash]bool)}
+	if !isNew {
+		updated.IsHolding = old.IsHolding
+		updated.Uptime = old.Uptime
+	} else {
+		updated.Uptime.Availability = neutralAvailability
+	}
+	world.publishNode(meta.PeerInfo.ID, updated)
+	world.lk.Unlock()
+
+	if isNew {
+		world.notifyMembershipChange()
+	}
+	return
+}
+
+// MetaVersions returns this node's NodeMetaTable version vector, to be sent
+// as a NodeMetaRequest to a gossip partner.
+func (world *World) MetaVersions() map[peer.ID]uint64 {
+	return world.metaTable.Versions()
+}
+
+// MetaSince returns the NodeMeta entries newer than the given version
+// vector, to be sent back as a NodeMetaResponse.
+func (world *World) MetaSince(versions map[peer.ID]uint64) []*NodeMeta {
+	return world.metaTable.Since(versions)
+}
+
+// NodeMetaRequest asks a gossip partner for any NodeMeta newer than the
+// sender's known version vector.
+type NodeMetaRequest struct {
+	Versions map[peer.ID]uint64
+}
+
+// NodeMetaResponse carries the NodeMeta entries the responder has that are
+// newer than the versions the requester advertised.
+type NodeMetaResponse struct {
+	Metas []*NodeMeta
+}
+
+// NodeMetaReceiver answers a NODEMETA_REQUEST: it computes the NodeMeta
+// delta the requester is missing, relative to the version vector it sent.
+// It has the (h *Holochain, body interface{}) (interface{}, error) shape
+// expected of this package's message receivers. It is NOT registered against
+// NODEMETA_REQUEST anywhere in this tree -- the action-receiver dispatch
+// table that maps message types to receivers lives outside this source
+// snapshot, so wiring NODEMETA_REQUEST into it has to happen there.
+func NodeMetaReceiver(h *Holochain, body interface{}) (response interface{}, err error) {
+	req, ok := body.(NodeMetaRequest)
+	if !ok {
+		err = ErrInvalidNodeMeta
+		return
+	}
+	response = NodeMetaResponse{Metas: h.world.MetaSince(req.Versions)}
+	return
+}
+
+// GossipNodeMeta sends this node's version vector to peer id as a
+// NODEMETA_REQUEST, and folds whatever NodeMeta entries come back in the
+// NODEMETA_RESPONSE into the local NodeMetaTable and world model, so two
+// gossiping nodes converge on the same table by exchanging only their
+// deltas rather than the whole thing.
+func (h *Holochain) GossipNodeMeta(id peer.ID) (err error) {
+	req := NodeMetaRequest{Versions: h.world.MetaVersions()}
+	var resp interface{}
+	resp, err = h.Send(GossipProtocol, id, NODEMETA_REQUEST, req, 0)
+	if err != nil {
+		return
+	}
+	response, ok := resp.(NodeMetaResponse)
+	if !ok {
+		err = ErrInvalidNodeMeta
+		return
+	}
+	for _, meta := range response.Metas {
+		// A stale or failed-verification entry just means some other peer
+		// already caught us up (or is misbehaving); either way it's not
+		// fatal to the rest of the exchange.
+		h.world.PutMeta(meta)
+	}
+	return
+}
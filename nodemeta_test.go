@@ -0,0 +1,88 @@
+package holochain
+
+import (
+	"crypto/rand"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"testing"
+	"time"
+)
+
+func TestPutMetaRejectsBadSignatureAndStaleVersion(t *testing.T) {
+	priv, pub, err := ic.GenerateKeyPair(ic.RSA, 1024, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating test key pair: %v", err)
+	}
+
+	world := NewWorld(testPeerID("me"), nil)
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed deriving peer ID from test key pair: %v", err)
+	}
+	pi := pstore.PeerInfo{ID: id}
+
+	meta, err := NewNodeMeta(pi, priv, pub, 1, map[string]string{"role": "warrant-holder"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed signing node meta: %v", err)
+	}
+	if err = world.PutMeta(meta); err != nil {
+		t.Fatalf("expected valid signed meta to be accepted, got %v", err)
+	}
+
+	record := world.GetNodeRecord(id)
+	if record == nil {
+		t.Fatal("expected PutMeta to add a new peer without an explicit AddNode call")
+	}
+
+	stale, err := NewNodeMeta(pi, priv, pub, 1, map[string]string{"role": "warrant-holder"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed signing stale node meta: %v", err)
+	}
+	if err = world.PutMeta(stale); err != ErrNodeMetaStale {
+		t.Fatalf("expected stale version to be rejected, got %v", err)
+	}
+
+	tampered := *meta
+	tampered.Tags = map[string]string{"role": "attacker"}
+	tampered.Version = 2
+	if err = world.PutMeta(&tampered); err != ErrNodeMetaSignature {
+		t.Fatalf("expected tampered meta to fail signature verification, got %v", err)
+	}
+}
+
+// TestPutMetaRejectsIdentitySpoofing makes sure an attacker with their own
+// valid keypair can't sign a NodeMeta claiming a victim's PeerInfo.ID: the
+// signature alone verifies fine, but verify() must also check that the ID
+// was actually derived from the attached PubKey.
+func TestPutMetaRejectsIdentitySpoofing(t *testing.T) {
+	victimPriv, victimPub, err := ic.GenerateKeyPair(ic.RSA, 1024, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating victim key pair: %v", err)
+	}
+	victimID, err := peer.IDFromPublicKey(victimPub)
+	if err != nil {
+		t.Fatalf("failed deriving victim peer ID: %v", err)
+	}
+
+	attackerPriv, attackerPub, err := ic.GenerateKeyPair(ic.RSA, 1024, rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating attacker key pair: %v", err)
+	}
+
+	world := NewWorld(testPeerID("me"), nil)
+	world.AddNode(pstore.PeerInfo{ID: victimID}, victimPub)
+
+	spoofed, err := NewNodeMeta(pstore.PeerInfo{ID: victimID}, attackerPriv, attackerPub, 1, map[string]string{"role": "attacker"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("failed signing spoofed node meta: %v", err)
+	}
+	if err = world.PutMeta(spoofed); err != ErrNodeMetaIdentityMismatch {
+		t.Fatalf("expected spoofed meta to be rejected for ID/PubKey mismatch, got %v", err)
+	}
+
+	record := world.GetNodeRecord(victimID)
+	if record == nil || record.PubKey != victimPub {
+		t.Fatalf("expected victim's NodeRecord to be unchanged by the rejected spoofed meta")
+	}
+}
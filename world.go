@@ -11,23 +11,165 @@ import (
 	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// NodeRecord stores the necessary information about other nodes in the world model
+// uptimeEWMAWeight is how strongly the most recent seen/down observation
+// moves a node's availability score.
+const uptimeEWMAWeight = 0.2
+
+// neutralAvailability seeds a freshly added peer's Uptime.Availability until
+// RecordSeen/RecordDown have actually observed it. Without this, every new
+// peer starts at the zero value (0.0) while "me" is scored at 1.0 in
+// scoreByResilience, so with Alpha<1 a never-yet-observed peer could never
+// outscore this node, and with MinAvailability>0 every unobserved peer would
+// be dropped from the responsible set outright. Starting neutral avoids both
+// until real observations pull a peer's score up or down.
+const neutralAvailability = 1.0
+
+// Uptime tracks how long and how reliably a peer has been observed online,
+// used to weight responsibility selection toward nodes likely to still be
+// around rather than purely toward whoever is XOR-closest right now.
+type Uptime struct {
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	TotalUp      time.Duration
+	Availability float64 // EWMA score in [0,1]
+}
+
+// NodeRecord stores the necessary information about other nodes in the world
+// model. It's treated as immutable once published into World's node map:
+// any update (SetNodeHolding, RecordSeen, ...) builds a new *NodeRecord and
+// atomically swaps it in, rather than mutating the one callers may be
+// holding a reference to.
 type NodeRecord struct {
 	PeerInfo  pstore.PeerInfo
 	PubKey    ic.PubKey
 	IsHolding map[Hash]bool
+	Uptime    Uptime
+}
+
+// ResilienceConfig tunes how strongly a node's observed uptime influences
+// responsibility selection, on top of raw XOR-closeness to the hash.
+// Installed via World.SetResilienceConfig.
+type ResilienceConfig struct {
+	// Alpha weights XOR-closeness against availability when scoring
+	// candidates. At Alpha>=1.0 (the default) scoring is pure closeness,
+	// matching pre-resilience behavior. Below that:
+	//   score = Alpha*closeness*availability + (1-Alpha)*availability
+	// This deliberately departs from a plain linear blend
+	// (Alpha*closeness + (1-Alpha)*availability): under a linear blend, a
+	// consistently-unreliable-but-closest node can still outscore a
+	// reliable-but-farther one whenever Alpha>=0.5, because EWMA
+	// availability asymptotically approaches but never reaches exactly 0
+	// or 1. Scaling closeness by the candidate's own availability means a
+	// sufficiently unreliable closest node can never win, at any
+	// Alpha<1.0 -- see scoreByResilience. This is also why it isn't the
+	// plain alpha*(1-normalizedDistance) + (1-alpha)*availability blend
+	// originally proposed for this feature.
+	Alpha float64
+	// MinAvailability is the floor below which a node is never selected as
+	// responsible, no matter how XOR-close it is. Applied regardless of
+	// Alpha.
+	MinAvailability float64
+}
+
+// DefaultResilienceConfig weights purely by XOR-closeness, matching the
+// selection behavior before resilience scoring existed.
+var DefaultResilienceConfig = ResilienceConfig{Alpha: 1.0, MinAvailability: 0}
+
+// Tier identifies how much of a hash's data a peer is expected to hold.
+type Tier int
+
+const (
+	// TierNone means the node isn't responsible for a hash at any tier.
+	TierNone Tier = iota - 1
+	// TierHolder peers hold the full entry.
+	TierHolder
+	// TierIndex peers hold only the entry's links/index, not the full entry.
+	TierIndex
+	// TierWarrant peers hold no entry data at all; they just watch for
+	// deletions/mods (warrants) against it.
+	TierWarrant
+)
+
+// TierConfig controls how many peers beyond the RedundancyFactor full-entry
+// holders get assigned to the lighter-weight index and warrant tiers.
+// Installed via World.SetTierConfig.
+type TierConfig struct {
+	IndexRedundancy   int
+	WarrantRedundancy int
+}
+
+// ResponsibilityTiers holds, for a single hash, the other peers responsible
+// at each Tier, and which Tier this node itself occupies.
+type ResponsibilityTiers struct {
+	Holders  []peer.ID
+	Index    []peer.ID
+	Warrants []peer.ID
+	MyTier   Tier
+}
+
+// PeersForTier returns the peers assigned to the given tier.
+func (t ResponsibilityTiers) PeersForTier(tier Tier) []peer.ID {
+	switch tier {
+	case TierHolder:
+		return t.Holders
+	case TierIndex:
+		return t.Index
+	case TierWarrant:
+		return t.Warrants
+	}
+	return nil
+}
+
+// UptimePersister is implemented optionally by a HashTable that wants to
+// persist uptime stats across restarts so scores don't reset to zero.
+type UptimePersister interface {
+	SaveUptime(id peer.ID, uptime Uptime) error
+	LoadUptime(id peer.ID) (uptime Uptime, found bool)
+}
+
+// nodesSnapshot is an immutable view of the known nodes: the map itself and
+// a pre-built slice of its keys, published together so AllNodes never has
+// to rebuild its slice from the map under lock.
+type nodesSnapshot struct {
+	byID map[peer.ID]*NodeRecord
+	list []peer.ID
+}
+
+func emptyNodesSnapshot() *nodesSnapshot {
+	return &nodesSnapshot{byID: make(map[peer.ID]*NodeRecord)}
 }
 
 // World holds the data of a nodes' world model
 type World struct {
 	me          peer.ID
-	nodes       map[peer.ID]*NodeRecord
-	responsible map[Hash][]peer.ID
+	nodesVal    atomic.Value // holds *nodesSnapshot; read without world.lk
+	responsible map[Hash]ResponsibilityTiers
+	tierConfig  TierConfig
 	ht          HashTable
 
+	membershipListeners []func()
+	hashAddedListeners  []func(Hash)
+
+	resilience ResilienceConfig
+	now        func() time.Time
+
+	// forgetDropsLocal controls whether ForgetResponsible tells its caller to
+	// also drop this node's own local DHT entry for a hash it's no longer
+	// responsible for, rather than just removing it from the responsible map.
+	// Off by default; see SetForgetDropsLocal.
+	forgetDropsLocal bool
+
+	metaTable *NodeMetaTable
+	scheduler *HoldRequestScheduler
+
+	// lk serializes writers and guards everything above except nodesVal,
+	// which readers load lock-free off its published snapshot.
 	lk sync.RWMutex
 }
 
@@ -36,42 +178,126 @@ var ErrNodeNotFound = errors.New("node not found")
 // NewWorld creates and empty world model
 func NewWorld(me peer.ID, ht HashTable) *World {
 	world := World{me: me}
-	world.nodes = make(map[peer.ID]*NodeRecord)
-	world.responsible = make(map[Hash][]peer.ID)
+	world.nodesVal.Store(emptyNodesSnapshot())
+	world.responsible = make(map[Hash]ResponsibilityTiers)
 	world.ht = ht
+	world.resilience = DefaultResilienceConfig
+	world.now = time.Now
+	world.metaTable = NewNodeMetaTable()
+	world.scheduler = NewHoldRequestScheduler(DefaultHoldSchedulerBufLimit, DefaultHoldSchedulerMinRecharge, DefaultHoldSchedulerMaxAge, nil)
 	return &world
 }
 
-// GetNodeRecord returns the peer's node record
-// NOTE: do not modify the contents of the returned record! not thread safe
-func (world *World) GetNodeRecord(ID peer.ID) (record *NodeRecord) {
+// loadNodes returns the currently published nodes snapshot without taking
+// world.lk.
+func (world *World) loadNodes() *nodesSnapshot {
+	return world.nodesVal.Load().(*nodesSnapshot)
+}
+
+// publishNode copy-on-writes the node snapshot: it builds a new map and key
+// list with id's record replaced (or removed, if record is nil) and
+// atomically swaps them in. Callers must hold world.lk so concurrent
+// publishes don't race each other and lose an update.
+func (world *World) publishNode(id peer.ID, record *NodeRecord) {
+	old := world.loadNodes()
+	byID := make(map[peer.ID]*NodeRecord, len(old.byID)+1)
+	for k, v := range old.byID {
+		byID[k] = v
+	}
+	if record == nil {
+		delete(byID, id)
+	} else {
+		byID[id] = record
+	}
+	list := make([]peer.ID, 0, len(byID))
+	for k := range byID {
+		list = append(list, k)
+	}
+	world.nodesVal.Store(&nodesSnapshot{byID: byID, list: list})
+}
+
+// SetHoldRequestScheduler installs the HoldRequestScheduler used to
+// flow-control outgoing hold requests, wiring its dispatch function to
+// actually send them (e.g. over the DHT).
+func (world *World) SetHoldRequestScheduler(s *HoldRequestScheduler) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.scheduler = s
+}
+
+// SetResilienceConfig sets the alpha/availability-floor used to weight
+// uptime against XOR-closeness when computing responsibility. This is the
+// World-level seam for ResilienceConfig; this tree has no DHTConfig
+// definition to carry it on and thread through to World, so a DNA-level
+// config knob for it is out of scope here.
+func (world *World) SetResilienceConfig(cfg ResilienceConfig) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.resilience = cfg
+}
+
+// SetTierConfig sets how many peers beyond the full-entry holders get
+// assigned to the lighter-weight index and warrant tiers. Same caveat as
+// SetResilienceConfig: this is a World-level setter, not a DHTConfig field,
+// since DHTConfig isn't defined in this tree.
+func (world *World) SetTierConfig(cfg TierConfig) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.tierConfig = cfg
+}
+
+// SetForgetDropsLocal controls whether ForgetResponsible reports that this
+// node's local DHT entry for a hash it's no longer responsible for should be
+// dropped (HoldingTask then drops it via DropLocal). Off by
+// default, since dropping the local copy is optional and callers that want
+// it must also be prepared to re-fetch the entry if responsibility returns.
+func (world *World) SetForgetDropsLocal(drop bool) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.forgetDropsLocal = drop
+}
+
+// DropLocal deletes hash from the local HashTable. Callers that walk the
+// table (e.g. HoldingTask via HashTable.Iterate) must wait until the walk
+// finishes before calling this, since deleting mid-iteration is unsafe.
+func (world *World) DropLocal(hash Hash) {
 	world.lk.RLock()
-	defer world.lk.RUnlock()
-	record = world.nodes[ID]
+	ht := world.ht
+	world.lk.RUnlock()
+	if ht != nil {
+		ht.Del(hash)
+	}
+}
+
+// GetNodeRecord returns the peer's node record. The returned record is
+// immutable; updating a node's state (SetNodeHolding, RecordSeen, ...)
+// never modifies a record callers may be holding, it publishes a new one.
+func (world *World) GetNodeRecord(ID peer.ID) (record *NodeRecord) {
+	record = world.loadNodes().byID[ID]
 	return
 }
 
 // SetNodeHolding marks a node as holding a particular hash
 func (world *World) SetNodeHolding(ID peer.ID, hash Hash) (err error) {
-	//fmt.Printf("Setting Holding for %v of holding %v nodes:%v\n", ID, hash, world.nodes)
 	world.lk.Lock()
 	defer world.lk.Unlock()
-	record := world.nodes[ID]
-	if record == nil {
+	old := world.loadNodes().byID[ID]
+	if old == nil {
 		err = ErrNodeNotFound
 		return
 	}
-	record.IsHolding[hash] = true
+	holding := make(map[Hash]bool, len(old.IsHolding)+1)
+	for k, v := range old.IsHolding {
+		holding[k] = v
+	}
+	holding[hash] = true
+	world.publishNode(ID, &NodeRecord{PeerInfo: old.PeerInfo, PubKey: old.PubKey, IsHolding: holding, Uptime: old.Uptime})
 	return
 }
 
 // IsHolding returns whether a node is holding a particular hash
 func (world *World) IsHolding(ID peer.ID, hash Hash) (holding bool, err error) {
-	world.lk.RLock()
-	defer world.lk.RUnlock()
-	//fmt.Printf("Looking to see if %v is holding %v\n", ID, hash)
-	//fmt.Printf("NODES:%v\n", world.nodes)
-	record := world.nodes[ID]
+	record := world.loadNodes().byID[ID]
 	if record == nil {
 		err = ErrNodeNotFound
 		return
@@ -80,32 +306,141 @@ func (world *World) IsHolding(ID peer.ID, hash Hash) (holding bool, err error) {
 	return
 }
 
-// AllNodes returns a list of all the nodes in the world model.
+// AllNodes returns a list of all the nodes in the world model. The returned
+// slice is a copy of the published snapshot's list, safe for the caller to
+// sort, append to, or otherwise mutate without racing concurrent lock-free
+// readers of that snapshot.
 func (world *World) AllNodes() (nodes []peer.ID, err error) {
-	world.lk.RLock()
-	defer world.lk.RUnlock()
-	nodes, err = world.allNodes()
+	list := world.loadNodes().list
+	nodes = make([]peer.ID, len(list))
+	copy(nodes, list)
 	return
 }
 
+// allNodes is an in-package alias for AllNodes, kept so call sites like
+// nodesByHash read the same way as external package callers.
 func (world *World) allNodes() (nodes []peer.ID, err error) {
-	nodes = make([]peer.ID, len(world.nodes))
+	return world.AllNodes()
+}
 
-	i := 0
-	for k := range world.nodes {
-		nodes[i] = k
-		i++
+// AddNode adds a node to the world model
+func (world *World) AddNode(pi pstore.PeerInfo, pubKey ic.PubKey) (err error) {
+	world.lk.Lock()
+	rec := &NodeRecord{PeerInfo: pi, PubKey: pubKey, IsHolding: make(map[Hash]bool)}
+	if old := world.loadNodes().byID[pi.ID]; old != nil {
+		rec.Uptime = old.Uptime
+	} else {
+		rec.Uptime.Availability = neutralAvailability
+	}
+	if persister, ok := world.ht.(UptimePersister); ok {
+		if uptime, found := persister.LoadUptime(pi.ID); found {
+			rec.Uptime = uptime
+		}
 	}
+	world.publishNode(pi.ID, rec)
+	world.lk.Unlock()
+	world.notifyMembershipChange()
 	return
 }
 
-// AddNode adds a node to the world model
-func (world *World) AddNode(pi pstore.PeerInfo, pubKey ic.PubKey) (err error) {
+// RecordSeen marks a node as having just been observed, via gossip or
+// heartbeat, bumping its availability score and accumulated uptime.
+func (world *World) RecordSeen(id peer.ID) {
+	world.lk.Lock()
+	old := world.loadNodes().byID[id]
+	if old == nil {
+		world.lk.Unlock()
+		return
+	}
+	now := world.now()
+	uptime := old.Uptime
+	if uptime.FirstSeen.IsZero() {
+		uptime.FirstSeen = now
+	}
+	if !uptime.LastSeen.IsZero() {
+		uptime.TotalUp += now.Sub(uptime.LastSeen)
+	}
+	uptime.LastSeen = now
+	uptime.Availability = ewma(uptime.Availability, 1, uptimeEWMAWeight)
+	world.publishNode(id, &NodeRecord{PeerInfo: old.PeerInfo, PubKey: old.PubKey, IsHolding: old.IsHolding, Uptime: uptime})
+	ht := world.ht
+	world.lk.Unlock()
+	saveUptime(ht, id, uptime)
+}
+
+// RecordDown marks a node as having been observed missing (e.g. a gossip
+// round or heartbeat it should have responded to went unanswered), pulling
+// its availability score down.
+func (world *World) RecordDown(id peer.ID) {
+	world.lk.Lock()
+	old := world.loadNodes().byID[id]
+	if old == nil {
+		world.lk.Unlock()
+		return
+	}
+	uptime := old.Uptime
+	uptime.Availability = ewma(uptime.Availability, 0, uptimeEWMAWeight)
+	world.publishNode(id, &NodeRecord{PeerInfo: old.PeerInfo, PubKey: old.PubKey, IsHolding: old.IsHolding, Uptime: uptime})
+	ht := world.ht
+	world.lk.Unlock()
+	saveUptime(ht, id, uptime)
+}
+
+func saveUptime(ht HashTable, id peer.ID, uptime Uptime) {
+	if persister, ok := ht.(UptimePersister); ok {
+		persister.SaveUptime(id, uptime)
+	}
+}
+
+// ewma blends a new sample into a running exponentially-weighted moving average.
+func ewma(prev, sample, weight float64) float64 {
+	return weight*sample + (1-weight)*prev
+}
+
+// OnMembershipChange registers a callback that's triggered whenever the set
+// of known nodes changes, so responsibility can be recomputed immediately
+// rather than waiting for the next periodic HoldingTask run.
+func (world *World) OnMembershipChange(fn func()) {
 	world.lk.Lock()
 	defer world.lk.Unlock()
-	rec := NodeRecord{PeerInfo: pi, PubKey: pubKey, IsHolding: make(map[Hash]bool)}
-	world.nodes[pi.ID] = &rec
-	return
+	world.membershipListeners = append(world.membershipListeners, fn)
+}
+
+// OnHashAdded registers a callback that's triggered whenever a new hash
+// shows up in the local hash table, so its responsibility can be computed
+// as soon as it's known about rather than waiting for the next periodic
+// HoldingTask run.
+func (world *World) OnHashAdded(fn func(Hash)) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	world.hashAddedListeners = append(world.hashAddedListeners, fn)
+}
+
+// notifyMembershipChange fires the registered membership-change listeners.
+// must be called without holding world.lk.
+func (world *World) notifyMembershipChange() {
+	world.lk.RLock()
+	listeners := make([]func(), len(world.membershipListeners))
+	copy(listeners, world.membershipListeners)
+	world.lk.RUnlock()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// NotifyHashAdded fires the registered hash-added listeners for hash. It's
+// meant to be called by the HashTable implementation's Put path whenever a
+// new hash is stored locally, so responsibility for it gets computed right
+// away instead of waiting for the next periodic HoldingTask run.
+// must be called without holding world.lk.
+func (world *World) NotifyHashAdded(hash Hash) {
+	world.lk.RLock()
+	listeners := make([]func(Hash), len(world.hashAddedListeners))
+	copy(listeners, world.hashAddedListeners)
+	world.lk.RUnlock()
+	for _, fn := range listeners {
+		fn(hash)
+	}
 }
 
 // NodesByHash returns a sorted list of peers, including "me" by distance from a hash
@@ -119,6 +454,57 @@ func (world *World) nodesByHash(hash Hash) (nodes []peer.ID, err error) {
 	return
 }
 
+// scoreByResilience re-ranks peers already sorted by XOR-closeness to a hash,
+// blending that closeness with each peer's observed availability so that a
+// consistently-unreliable node doesn't get picked as responsible just
+// because it happens to be closest right now -- see ResilienceConfig.Alpha
+// for the scoring formula and why it isn't a plain linear blend. Candidates
+// below MinAvailability are dropped from the result entirely, regardless of
+// Alpha, so a configured floor is never silently ignored.
+func (world *World) scoreByResilience(sorted []peer.ID) []peer.ID {
+	cfg := world.resilience
+	type scored struct {
+		id    peer.ID
+		score float64
+	}
+	n := len(sorted)
+	candidates := make([]scored, 0, n)
+	for i, id := range sorted {
+		closeness := 1.0
+		if n > 1 {
+			closeness = 1.0 - float64(i)/float64(n-1)
+		}
+		availability := 1.0
+		if id != world.me {
+			if record := world.loadNodes().byID[id]; record != nil {
+				availability = record.Uptime.Availability
+			}
+		}
+		if availability < cfg.MinAvailability {
+			continue
+		}
+		if cfg.Alpha >= 1.0 {
+			candidates = append(candidates, scored{id, closeness})
+			continue
+		}
+		// closeness is scaled by the candidate's own availability, so an
+		// unreliable node doesn't keep full closeness credit just because
+		// XOR-distance has no notion of reliability: a closest node with
+		// availability near 0 can never outscore a farther, reliable one,
+		// no matter how close Alpha keeps it to the old closeness-only
+		// behavior.
+		candidates = append(candidates, scored{id, cfg.Alpha*closeness*availability + (1-cfg.Alpha)*availability})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	result := make([]peer.ID, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.id
+	}
+	return result
+}
+
 /*
 func (world *World) NodeRecordsByHash(hash Hash) (records []*NodeRecord, err error) {
 
@@ -131,36 +517,74 @@ func (world *World) NodeRecordsByHash(hash Hash) (records []*NodeRecord, err err
 	return
 }*/
 
-// UpdateResponsible calculates the list of nodes believed to be responsible for a given hash
+// withoutMe returns nodes minus world.me, preserving order.
+func withoutMe(nodes []peer.ID, me peer.ID) []peer.ID {
+	selected := make([]peer.ID, 0, len(nodes))
+	for _, id := range nodes {
+		if id != me {
+			selected = append(selected, id)
+		}
+	}
+	return selected
+}
+
+// UpdateResponsible calculates the tiers of nodes believed to be responsible
+// for a given hash: the top redundancy peers by score are full-entry
+// holders, the next IndexRedundancy are index/link-only holders, and the
+// next WarrantRedundancy after that are warrant-only observers.
 // note that if redundancy is 0 the assumption is that all nodes are responsible
 func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bool, err error) {
 	world.lk.Lock()
 	defer world.lk.Unlock()
 	var nodes []peer.ID
 	if redundancy == 0 {
-		world.responsible[hash] = nil
+		world.responsible[hash] = ResponsibilityTiers{MyTier: TierHolder}
 		responsible = true
-	} else if redundancy > 1 {
+	} else if redundancy >= 1 {
 		nodes, err = world.nodesByHash(hash)
 		if err != nil {
 			return
 		}
-		// TODO add in resilince calculations with uptime
-		i := 0
-		for i = 0; i < redundancy; i++ {
-			if nodes[i] == world.me {
+		nodes = world.scoreByResilience(nodes)
+
+		holderEnd := redundancy
+		if holderEnd > len(nodes) {
+			holderEnd = len(nodes)
+		}
+		indexEnd := holderEnd + world.tierConfig.IndexRedundancy
+		if indexEnd > len(nodes) {
+			indexEnd = len(nodes)
+		}
+		warrantEnd := indexEnd + world.tierConfig.WarrantRedundancy
+		if warrantEnd > len(nodes) {
+			warrantEnd = len(nodes)
+		}
+
+		myTier := TierNone
+		for i, id := range nodes[:warrantEnd] {
+			if id == world.me {
 				responsible = true
+				switch {
+				case i < holderEnd:
+					myTier = TierHolder
+				case i < indexEnd:
+					myTier = TierIndex
+				default:
+					myTier = TierWarrant
+				}
 				break
 			}
 		}
-		// if me is included in the range of nodes that are close to the has
-		// add this hash (and other nodes) to the responsible map
-		// otherwise delete the item from the responsible map
+		// if me is included in the range of nodes close to the hash at any
+		// tier, add this hash (and the other nodes at each tier) to the
+		// responsible map, otherwise delete the item from the responsible map
 		if responsible {
-			// remove myself from the nodes list so I can add set the
-			// responsible nodes
-			nodes = append(nodes[:i], nodes[i+1:redundancy]...)
-			world.responsible[hash] = nodes
+			world.responsible[hash] = ResponsibilityTiers{
+				Holders:  withoutMe(nodes[:holderEnd], world.me),
+				Index:    withoutMe(nodes[holderEnd:indexEnd], world.me),
+				Warrants: withoutMe(nodes[indexEnd:warrantEnd], world.me),
+				MyTier:   myTier,
+			}
 		} else {
 			delete(world.responsible, hash)
 		}
@@ -170,6 +594,39 @@ func (world *World) UpdateResponsible(hash Hash, redundancy int) (responsible bo
 	return
 }
 
+// MyTier returns which tier, if any, this node occupies for hash.
+func (world *World) MyTier(hash Hash) Tier {
+	world.lk.RLock()
+	defer world.lk.RUnlock()
+	tiers, ok := world.responsible[hash]
+	if !ok {
+		return TierNone
+	}
+	return tiers.MyTier
+}
+
+// PeersForTier returns the peers, other than this node, assigned to the
+// given tier for hash.
+func (world *World) PeersForTier(hash Hash, tier Tier) []peer.ID {
+	world.lk.RLock()
+	defer world.lk.RUnlock()
+	return world.responsible[hash].PeersForTier(tier)
+}
+
+// ForgetResponsible removes a hash from the responsible map because this
+// node is no longer one of the nodes responsible for holding it, and reports
+// whether the caller should also drop the local DHT entry for it. It never
+// touches the DHT itself: callers like HoldingTask walk the DHT via
+// HashTable.Iterate, and deleting from a table while iterating it is unsafe,
+// so the actual local delete must happen once the walk is done.
+func (world *World) ForgetResponsible(hash Hash) (dropLocal bool) {
+	world.lk.Lock()
+	defer world.lk.Unlock()
+	delete(world.responsible, hash)
+	dropLocal = world.forgetDropsLocal
+	return
+}
+
 // Responsible returns a list of all the entries I'm responsible for holding
 func (world *World) Responsible() (entries []Hash, err error) {
 	world.lk.RLock()
@@ -184,33 +641,88 @@ func (world *World) Responsible() (entries []Hash, err error) {
 	return
 }
 
-// Overlap returns a list of all the nodes that overlap for a given hash
-func (h *Holochain) Overlap(hash Hash) (overlap []peer.ID, err error) {
+// Overlap returns a list of all the nodes that overlap for a given hash at
+// the given tier, so DHT put/get can address lighter-weight index- and
+// warrant-tier peers separately from full-entry holders.
+func (h *Holochain) Overlap(hash Hash, tier Tier) (overlap []peer.ID, err error) {
 	h.world.lk.RLock()
 	defer h.world.lk.RUnlock()
 	if h.nucleus.dna.DHTConfig.RedundancyFactor == 0 {
 		overlap, err = h.world.allNodes()
 	} else {
-		overlap = h.world.responsible[hash]
+		overlap = h.world.responsible[hash].PeersForTier(tier)
 	}
 	return
 }
 
-func HoldingTask(h *Holochain) {
-	/*	h.dht.Iterate(func(hash Hash) bool {
-		//TODO forget the hashes we are no longer responsible for
-		//TODO this really shouldn't be called in the holding task
-		//     but instead should be called with the Node list or hash list changes.
-		h.world.UpdateResponsible(hash, h.RedundancyFactor())
-
-		// TODO make this more efficient by collecting up a list of updates
-		// per node rather than making the hold request over and over
-		overlap, err := h.Overlap(hash)
-		if err != nil {
-			for _, nodeID := range overlap {
+// HoldReqBatch carries the set of hashes a peer is being asked to hold in a
+// single request, rather than one hold request per (hash, peer) pair.
+type HoldReqBatch struct {
+	Hashes []Hash
+}
 
-			}
+// holdRequestCost is the flow-control cost charged against a peer's
+// HoldRequestScheduler token bucket for a single hold request.
+const holdRequestCost = 1.0
+
+// recalcResponsible recomputes responsibility for a single hash, queuing it
+// to be forgotten if this node has dropped out of the responsible set, and
+// otherwise adding hash to the pending batch for every peer it overlaps
+// with, so HoldingTask can send one request per peer instead of one per
+// (hash, peer) pair. It never deletes from the DHT directly: it runs inside
+// h.dht.Iterate, so the actual local drop (if any) must wait until the walk
+// finishes, hence the forgotten slice instead of an immediate ht.Del.
+func recalcResponsible(h *Holochain, hash Hash, batches map[peer.ID][]Hash, forgotten *[]Hash) {
+	responsible, err := h.world.UpdateResponsible(hash, h.RedundancyFactor())
+	if err != nil {
+		return
+	}
+	if !responsible {
+		if h.world.ForgetResponsible(hash) {
+			*forgotten = append(*forgotten, hash)
 		}
+		return
+	}
+	overlap, err := h.Overlap(hash, TierHolder)
+	if err != nil {
+		return
+	}
+	for _, nodeID := range overlap {
+		batches[nodeID] = append(batches[nodeID], hash)
+	}
+}
+
+// HoldingTask runs a pass over the local hash table, recomputing which
+// hashes this node is responsible for holding, collecting them into one
+// HoldReqBatch per overlapping peer, and routing each peer's batch through
+// the World's HoldRequestScheduler as a single flow-controlled request
+// rather than firing one request per (hash, peer) pair. Any hash this node
+// is no longer responsible for is dropped from the local DHT, if
+// SetForgetDropsLocal enabled that, only after the walk below completes, so
+// the DHT is never mutated while h.dht.Iterate is still walking it.
+// RegisterHoldingTask wires this up as a World.OnMembershipChange /
+// World.OnHashAdded callback so it also runs as soon as the node or hash
+// list changes; the periodic run here is just a safety net for anything
+// those callbacks miss.
+func HoldingTask(h *Holochain) {
+	batches := make(map[peer.ID][]Hash)
+	var forgotten []Hash
+	h.dht.Iterate(func(hash Hash) bool {
+		recalcResponsible(h, hash, batches, &forgotten)
 		return false
-	})*/
+	})
+	for nodeID, hashes := range batches {
+		h.world.scheduler.Enqueue(nodeID, HoldReqBatch{Hashes: hashes}, float64(len(hashes))*holdRequestCost)
+	}
+	for _, hash := range forgotten {
+		h.world.DropLocal(hash)
+	}
+}
+
+// RegisterHoldingTask wires HoldingTask to run immediately whenever the
+// world's node list changes or a new hash is added to h's hash table, on
+// top of whatever periodic schedule also calls HoldingTask directly.
+func RegisterHoldingTask(h *Holochain) {
+	h.world.OnMembershipChange(func() { HoldingTask(h) })
+	h.world.OnHashAdded(func(Hash) { HoldingTask(h) })
 }
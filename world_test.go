@@ -0,0 +1,158 @@
+package holochain
+
+import (
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testPeerID(s string) peer.ID {
+	return peer.ID(s)
+}
+
+func TestScoreByResilienceDropsLowUptimeClosestNode(t *testing.T) {
+	world := NewWorld(testPeerID("me"), nil)
+	world.SetResilienceConfig(ResilienceConfig{Alpha: 0.5, MinAvailability: 0})
+
+	closestButFlaky := testPeerID("flaky")
+	fartherButReliable := testPeerID("reliable")
+
+	world.AddNode(pstore.PeerInfo{ID: closestButFlaky}, nil)
+	world.AddNode(pstore.PeerInfo{ID: fartherButReliable}, nil)
+
+	// flaky repeatedly goes down, reliable repeatedly stays up
+	for i := 0; i < 20; i++ {
+		world.RecordDown(closestButFlaky)
+		world.RecordSeen(fartherButReliable)
+	}
+
+	// simulate XOR-sort putting the flaky node first (closest)
+	sorted := []peer.ID{closestButFlaky, fartherButReliable}
+	scored := world.scoreByResilience(sorted)
+
+	if scored[0] != fartherButReliable {
+		t.Fatalf("expected reliable node to outrank flaky closest node, got order %v", scored)
+	}
+}
+
+// TestScoreByResilienceAppliesMinAvailabilityFloorRegardlessOfAlpha uses a
+// mocked clock to drive repeated RecordDown/RecordSeen cycles, then checks
+// that a node below MinAvailability is dropped from the responsible set
+// entirely -- not just re-ranked -- even though it's XOR-closest, and even
+// at Alpha=1.0 (the default), where the floor used to be skipped outright.
+func TestScoreByResilienceAppliesMinAvailabilityFloorRegardlessOfAlpha(t *testing.T) {
+	world := NewWorld(testPeerID("me"), nil)
+	world.SetResilienceConfig(ResilienceConfig{Alpha: 1.0, MinAvailability: 0.5})
+
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	world.now = func() time.Time { return clock }
+
+	closestButFlaky := testPeerID("flaky")
+	fartherButReliable := testPeerID("reliable")
+
+	world.AddNode(pstore.PeerInfo{ID: closestButFlaky}, nil)
+	world.AddNode(pstore.PeerInfo{ID: fartherButReliable}, nil)
+
+	for i := 0; i < 20; i++ {
+		clock = clock.Add(time.Second)
+		world.RecordDown(closestButFlaky)
+		world.RecordSeen(fartherButReliable)
+	}
+
+	// simulate XOR-sort putting the flaky node first (closest)
+	sorted := []peer.ID{closestButFlaky, fartherButReliable}
+	scored := world.scoreByResilience(sorted)
+
+	if len(scored) != 1 || scored[0] != fartherButReliable {
+		t.Fatalf("expected the low-availability node dropped entirely despite being XOR-closest, got %v", scored)
+	}
+}
+
+func TestMyTierAndPeersForTier(t *testing.T) {
+	world := NewWorld(testPeerID("me"), nil)
+	var hash Hash
+
+	holder := testPeerID("holder")
+	indexer := testPeerID("indexer")
+	warranter := testPeerID("warranter")
+
+	world.responsible[hash] = ResponsibilityTiers{
+		Holders:  []peer.ID{holder},
+		Index:    []peer.ID{indexer},
+		Warrants: []peer.ID{warranter},
+		MyTier:   TierIndex,
+	}
+
+	if tier := world.MyTier(hash); tier != TierIndex {
+		t.Fatalf("expected TierIndex, got %v", tier)
+	}
+	if tier := NewWorld(testPeerID("me"), nil).MyTier(hash); tier != TierNone {
+		t.Fatalf("expected TierNone for a world that never saw this hash, got %v", tier)
+	}
+
+	if peers := world.PeersForTier(hash, TierHolder); len(peers) != 1 || peers[0] != holder {
+		t.Fatalf("expected [holder], got %v", peers)
+	}
+	if peers := world.PeersForTier(hash, TierWarrant); len(peers) != 1 || peers[0] != warranter {
+		t.Fatalf("expected [warranter], got %v", peers)
+	}
+}
+
+// TestWorldConcurrentAccessHasNoDataRaces mixes AddNode, SetNodeHolding,
+// IsHolding, and UpdateResponsible across many goroutines; it's meant to be
+// run with -race, which catches any spot where a reader dereferences a
+// NodeRecord or node map that a concurrent writer is mutating in place.
+func TestWorldConcurrentAccessHasNoDataRaces(t *testing.T) {
+	world := NewWorld(testPeerID("me"), nil)
+	ids := make([]peer.ID, 10)
+	for i := range ids {
+		ids[i] = testPeerID(fmt.Sprintf("racer-%d", i))
+		world.AddNode(pstore.PeerInfo{ID: ids[i]}, nil)
+	}
+	var hash Hash
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := ids[i%len(ids)]
+			switch i % 4 {
+			case 0:
+				world.AddNode(pstore.PeerInfo{ID: id}, nil)
+			case 1:
+				world.SetNodeHolding(id, hash)
+			case 2:
+				world.IsHolding(id, hash)
+			case 3:
+				world.UpdateResponsible(hash, 3)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRecordSeenUsesInjectedClock(t *testing.T) {
+	world := NewWorld(testPeerID("me"), nil)
+	id := testPeerID("peer")
+	world.AddNode(pstore.PeerInfo{ID: id}, nil)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	world.now = func() time.Time { return clock }
+
+	world.RecordSeen(id)
+	clock = clock.Add(10 * time.Second)
+	world.RecordSeen(id)
+
+	record := world.GetNodeRecord(id)
+	if record.Uptime.TotalUp != 10*time.Second {
+		t.Fatalf("expected 10s of accumulated uptime, got %v", record.Uptime.TotalUp)
+	}
+	if record.Uptime.FirstSeen != start {
+		t.Fatalf("expected FirstSeen to be pinned to the clock's first tick, got %v", record.Uptime.FirstSeen)
+	}
+}